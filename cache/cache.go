@@ -0,0 +1,122 @@
+// Package cache provides a small on-disk cache under
+// $XDG_CACHE_HOME/metroline (or $HOME/.cache/metroline), used to avoid
+// re-fetching and re-parsing unchanged VATSIM data on every xbar
+// refresh.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// HTTPEntry is a cached HTTP response, saved alongside the validators
+// needed to make a conditional GET for it next time.
+type HTTPEntry struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+}
+
+// Dir returns the metroline cache directory, creating it if it doesn't
+// already exist.
+func Dir() (string, error) {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".cache")
+	}
+
+	dir := filepath.Join(base, "metroline")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// key derives a filesystem-safe file name for a cache entry.
+func key(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])), nil
+}
+
+// LoadHTTP returns the cached HTTPEntry for name, or nil if there is no
+// cache entry (or it can't be read).
+func LoadHTTP(name string) *HTTPEntry {
+	path, err := key(name + ".http")
+	if err != nil {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var e HTTPEntry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// SaveHTTP writes an HTTPEntry for name, overwriting any previous entry.
+func SaveHTTP(name string, e HTTPEntry) error {
+	path, err := key(name + ".http")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return gob.NewEncoder(f).Encode(e)
+}
+
+// LoadValue gob-decodes a previously-saved value of arbitrary type into
+// v, returning false if there's no cached value (or it can't be
+// decoded).
+func LoadValue(name string, v any) bool {
+	path, err := key(name + ".val")
+	if err != nil {
+		return false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	return gob.NewDecoder(f).Decode(v) == nil
+}
+
+// SaveValue gob-encodes v and saves it for name, overwriting any
+// previous value.
+func SaveValue(name string, v any) error {
+	path, err := key(name + ".val")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return gob.NewEncoder(f).Encode(v)
+}
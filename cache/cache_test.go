@@ -0,0 +1,45 @@
+package cache
+
+import "testing"
+
+func TestHTTPEntryRoundTrip(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	want := HTTPEntry{Body: []byte("hello"), ETag: `"abc123"`, LastModified: "Tue, 01 Jul 2025 00:00:00 GMT"}
+	if err := SaveHTTP("https://example.com/x", want); err != nil {
+		t.Fatalf("SaveHTTP: %v", err)
+	}
+
+	got := LoadHTTP("https://example.com/x")
+	if got == nil {
+		t.Fatal("LoadHTTP returned nil")
+	}
+	if string(got.Body) != string(want.Body) || got.ETag != want.ETag || got.LastModified != want.LastModified {
+		t.Errorf("LoadHTTP = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadHTTPMiss(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	if e := LoadHTTP("https://example.com/never-saved"); e != nil {
+		t.Errorf("LoadHTTP on miss = %+v, want nil", e)
+	}
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	type payload struct{ N int }
+	if err := SaveValue("k", payload{N: 42}); err != nil {
+		t.Fatalf("SaveValue: %v", err)
+	}
+
+	var got payload
+	if !LoadValue("k", &got) {
+		t.Fatal("LoadValue returned false")
+	}
+	if got.N != 42 {
+		t.Errorf("LoadValue = %+v, want N=42", got)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mmp/metroline/metar"
+	"github.com/mmp/metroline/track"
+)
+
+// AirportConfig is the on-disk representation of an Airport.
+type AirportConfig struct {
+	Name      string  `json:"name"`
+	Latitude  float32 `json:"latitude"`
+	Longitude float32 `json:"longitude"`
+	Elevation float32 `json:"elevation"` // field elevation in feet MSL
+}
+
+func (a AirportConfig) Airport() Airport {
+	return Airport{Name: a.Name, Location: [2]float32{a.Longitude, a.Latitude}, ElevationFt: int(a.Elevation)}
+}
+
+// MajorAirportConfig is the on-disk representation of a MajorAirport.
+type MajorAirportConfig struct {
+	AirportConfig
+	Satellites []AirportConfig `json:"satellites"`
+}
+
+func (m MajorAirportConfig) MajorAirport() MajorAirport {
+	major := MajorAirport{Airport: m.AirportConfig.Airport()}
+	for _, sat := range m.Satellites {
+		major.Satellites = append(major.Satellites, sat.Airport())
+	}
+	return major
+}
+
+// FacilityConfig is the on-disk description of a single ARTCC/TRACON to
+// monitor: where to find its roster of staffed positions, what icon to
+// show for it, and which airports' traffic it cares about.
+type FacilityConfig struct {
+	Name         string               `json:"name"`          // e.g. "ZNY"
+	PositionsURL string               `json:"positions_url"` // http(s) URL or local file path
+	IconPath     string               `json:"icon_path"`     // local path to a template PNG icon
+	Majors       []MajorAirportConfig `json:"majors"`
+}
+
+// Facility is a resolved, ready-to-report ARTCC/TRACON: its position
+// roster and icon have been loaded and its airports converted to the
+// runtime Airport/MajorAirport types.
+type Facility struct {
+	Name      string
+	Icon      []byte
+	Positions []Position
+	Majors    []MajorAirport
+}
+
+// LoadFacilities reads a facility config file (a JSON array of
+// FacilityConfig) and resolves each entry into a Facility, fetching its
+// position roster and icon.
+func LoadFacilities(path string) ([]Facility, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var configs []FacilityConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var facilities []Facility
+	for _, c := range configs {
+		positions, err := loadPositions(c.PositionsURL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: positions: %w", c.Name, err)
+		}
+
+		var icon []byte
+		if c.IconPath != "" {
+			if icon, err = os.ReadFile(c.IconPath); err != nil {
+				return nil, fmt.Errorf("%s: icon: %w", c.Name, err)
+			}
+		}
+
+		fac := Facility{Name: c.Name, Icon: icon, Positions: positions}
+		for _, m := range c.Majors {
+			fac.Majors = append(fac.Majors, m.MajorAirport())
+		}
+		facilities = append(facilities, fac)
+	}
+
+	return facilities, nil
+}
+
+// loadPositions fetches a facility's position roster from either an
+// http(s) URL or a local file path.
+func loadPositions(source string) ([]Position, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = FetchURL(source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []Position
+	if err := json.Unmarshal(data, &positions); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+// FacilityStats is the computed state for a facility on a single
+// VATSIM datafeed snapshot: traffic counts, active controllers, and
+// recently-departed aircraft. Both the xbar report and the -serve mode's
+// metrics/JSON endpoints are built from it.
+type FacilityStats struct {
+	Name              string
+	Departures        map[string]int
+	Arrivals          map[string]int
+	Traffic           int
+	ActiveControllers []Controller
+	CenterOnline      bool
+	RecentlyDeparted  int
+	GoArounds         int
+}
+
+// Stats computes this facility's FacilityStats for state. store is read
+// only; the caller must have already refreshed it for this run via
+// UpdateAllTracks so that RecentlyDeparted sees up-to-date position
+// history.
+func (f Facility) Stats(state *VATSIMState, store *track.Store, now time.Time) FacilityStats {
+	dep, arr, traffic := CountTraffic(state, f.Majors, store)
+	activeCtrls := ActiveControllers(state, f.Positions)
+	ctrOnline := slices.ContainsFunc(activeCtrls, func(c Controller) bool { return strings.HasSuffix(c.Callsign, "_CTR") })
+
+	return FacilityStats{
+		Name:              f.Name,
+		Departures:        dep,
+		Arrivals:          arr,
+		Traffic:           traffic,
+		ActiveControllers: activeCtrls,
+		CenterOnline:      ctrOnline,
+		RecentlyDeparted:  f.recentlyDeparted(state, store, now),
+		GoArounds:         f.goArounds(state, store),
+	}
+}
+
+// Report returns the online controller count, whether a center position
+// is among them, the traffic count, and the xbar menu body (everything
+// after the top-level title line) for this facility.
+func (f Facility) Report(state *VATSIMState, metars map[string]metar.Observation, store *track.Store, now time.Time) (online int, ctrOnline bool, traffic int, body string) {
+	stats := f.Stats(state, store, now)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", f.Name)
+
+	if len(stats.ActiveControllers) > 0 {
+		fmt.Fprintf(&b, "---\n")
+		for _, ctrl := range stats.ActiveControllers {
+			s := time.Since(ctrl.Logon)
+			h, m := int(s.Hours()), int(s.Minutes())-60*int(s.Hours())
+			fmt.Fprintf(&b, "%-9s %s (%d:%02d) | font=Monaco | href=https://nyartcc.org/controller/%d\n",
+				ctrl.Callsign, ctrl.Name, h, m, ctrl.CID)
+		}
+	}
+
+	fmt.Fprintf(&b, "---\n")
+	for _, major := range f.Majors {
+		fmt.Fprintf(&b, "%s %2dðŸ›« %2dðŸ›¬ | font=Monaco | href=https://vatsim-radar.com/airport/%s\n", major.Name,
+			stats.Departures[major.Name], stats.Arrivals[major.Name], major.Name)
+
+		if obs, ok := metars[major.Name]; ok {
+			fmt.Fprintf(&b, "--%s %s | font=Monaco\n", major.Name, formatMetar(obs))
+		}
+	}
+	fmt.Fprintf(&b, "%d recently departed | font=Monaco\n", stats.RecentlyDeparted)
+	if stats.GoArounds > 0 {
+		fmt.Fprintf(&b, "%d go-around(s) | font=Monaco\n", stats.GoArounds)
+	}
+
+	return len(stats.ActiveControllers), stats.CenterOnline, stats.Traffic, b.String()
+}
+
+// recentlyDeparted returns the number of aircraft that were on the
+// ground within the last 10 minutes and are now airborne within 30nm of
+// one of the facility's major airports. "On the ground" is judged from
+// groundspeed alone (see track.Aircraft.WasOnGroundWithin); where the
+// aircraft was on the ground is not checked. It only reads from store;
+// UpdateAllTracks is responsible for keeping it current.
+func (f Facility) recentlyDeparted(state *VATSIMState, store *track.Store, now time.Time) int {
+	recentlyDeparted := 0
+	for _, pilot := range state.Pilots {
+		ac, ok := store.Aircraft[pilot.CID]
+		if !ok || ac.OnGround || !ac.WasOnGroundWithin(now, 10*time.Minute) {
+			continue
+		}
+
+		loc := [2]float32{pilot.Longitude, pilot.Latitude}
+		for _, major := range f.Majors {
+			if major.DistanceTo(loc) < 30 {
+				recentlyDeparted++
+				break
+			}
+		}
+	}
+
+	return recentlyDeparted
+}
+
+// goArounds returns the number of aircraft within 30nm of one of the
+// facility's airports whose recent track shows a go-around (see
+// track.Aircraft.WentAround).
+func (f Facility) goArounds(state *VATSIMState, store *track.Store) int {
+	goArounds := 0
+	for _, pilot := range state.Pilots {
+		ac, ok := store.Aircraft[pilot.CID]
+		if !ok || !ac.WentAround() {
+			continue
+		}
+
+		loc := [2]float32{pilot.Longitude, pilot.Latitude}
+		for _, major := range f.Majors {
+			if major.DistanceTo(loc) < 30 {
+				goArounds++
+				break
+			}
+		}
+	}
+
+	return goArounds
+}
+
+// UpdateAllTracks feeds this run's pilot positions into store, once per
+// pilot across every configured facility (a pilot within range of more
+// than one facility would otherwise be fed conflicting updates once per
+// facility). A pilot is considered in range if within 500nm of any
+// facility's first major airport, matching CountTraffic's coarse 500nm
+// filter; its ground-elevation reference is the nearest airport across
+// all facilities, not just the one that brought it into range.
+func UpdateAllTracks(state *VATSIMState, facilities []Facility, store *track.Store, now time.Time) {
+	type anchor struct {
+		airport Airport
+		box     bbox
+	}
+	var anchors []anchor
+	for _, fac := range facilities {
+		if len(fac.Majors) == 0 {
+			continue
+		}
+		a := fac.Majors[0].Airport
+		anchors = append(anchors, anchor{airport: a, box: boxAround(a.Location, 500)})
+	}
+
+	for _, pilot := range state.Pilots {
+		loc := [2]float32{pilot.Longitude, pilot.Latitude}
+
+		inRange := false
+		for _, a := range anchors {
+			if a.airport.within(a.box, loc, 500) {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			continue
+		}
+
+		store.Update(pilot.CID, pilot.Callsign, now, pilot.Latitude, pilot.Longitude,
+			pilot.Altitude, pilot.Groundspeed, nearestFieldElevation(facilities, loc))
+	}
+}
+
+// nearestFieldElevation returns the elevation of the closest airport
+// (major or satellite, across all facilities) to loc, or 0 if none is
+// within 50nm.
+func nearestFieldElevation(facilities []Facility, loc [2]float32) int {
+	best := float32(math.MaxFloat32)
+	elevation := 0
+	for _, fac := range facilities {
+		for _, major := range fac.Majors {
+			if d := major.DistanceTo(loc); d < best {
+				best, elevation = d, major.ElevationFt
+			}
+			for _, sat := range major.Satellites {
+				if d := sat.DistanceTo(loc); d < best {
+					best, elevation = d, sat.ElevationFt
+				}
+			}
+		}
+	}
+	if best > 50 {
+		return 0
+	}
+	return elevation
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmp/metroline/track"
+)
+
+func TestLoadFacilities(t *testing.T) {
+	dir := t.TempDir()
+
+	positionsPath := filepath.Join(dir, "positions.json")
+	positions := `[{"callsign":"JFK_TWR","radioName":"Kennedy Tower","frequency":119.1}]`
+	if err := os.WriteFile(positionsPath, []byte(positions), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "facilities.json")
+	config := fmt.Sprintf(`[{"name":"N90","positions_url":%q,"majors":[
+		{"name":"KJFK","latitude":40.641766,"longitude":-73.780968,"elevation":13}
+	]}]`, positionsPath)
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	facilities, err := LoadFacilities(configPath)
+	if err != nil {
+		t.Fatalf("LoadFacilities: %v", err)
+	}
+	if len(facilities) != 1 {
+		t.Fatalf("len(facilities) = %d, want 1", len(facilities))
+	}
+
+	fac := facilities[0]
+	if fac.Name != "N90" {
+		t.Errorf("Name = %q, want N90", fac.Name)
+	}
+	if len(fac.Positions) != 1 || fac.Positions[0].Name != "JFK_TWR" {
+		t.Errorf("Positions = %+v, want one JFK_TWR entry", fac.Positions)
+	}
+	if len(fac.Majors) != 1 || fac.Majors[0].Name != "KJFK" || fac.Majors[0].ElevationFt != 13 {
+		t.Errorf("Majors = %+v, want one KJFK major with ElevationFt=13", fac.Majors)
+	}
+}
+
+func twoFacilities() []Facility {
+	return []Facility{
+		{
+			Name:   "N90",
+			Majors: []MajorAirport{{Airport: Airport{Name: "KJFK", Location: [2]float32{-73.780968, 40.641766}, ElevationFt: 13}}},
+		},
+		{
+			Name:   "A90",
+			Majors: []MajorAirport{{Airport: Airport{Name: "KBOS", Location: [2]float32{-71.00696, 42.362976}, ElevationFt: 20}}},
+		},
+	}
+}
+
+// TestUpdateAllTracksDedupesSharedPilot checks that a pilot within range
+// of more than one facility is only fed into the store once, not once
+// per facility that can see it.
+func TestUpdateAllTracksDedupesSharedPilot(t *testing.T) {
+	facilities := twoFacilities()
+
+	// Hartford, CT: well within 500nm of both KJFK and KBOS.
+	state := &VATSIMState{
+		Pilots: []Pilot{
+			{CID: 1, Callsign: "JBU1", Latitude: 41.76, Longitude: -72.68, Altitude: 5000, Groundspeed: 200},
+		},
+	}
+
+	store := track.NewStore()
+	now := time.Now()
+	UpdateAllTracks(state, facilities, store, now)
+
+	ac, ok := store.Aircraft[1]
+	if !ok {
+		t.Fatal("expected pilot 1 to be tracked")
+	}
+	if len(ac.Positions) != 1 {
+		t.Errorf("Positions = %d, want 1 (pilot is in range of both facilities but should only be updated once)", len(ac.Positions))
+	}
+}
+
+func TestNearestFieldElevationPicksCloserFacility(t *testing.T) {
+	facilities := twoFacilities()
+
+	// Near KJFK, well outside KBOS's 50nm radius.
+	if got := nearestFieldElevation(facilities, [2]float32{-73.8, 40.65}); got != 13 {
+		t.Errorf("nearestFieldElevation near KJFK = %d, want 13", got)
+	}
+
+	// Near KBOS instead.
+	if got := nearestFieldElevation(facilities, [2]float32{-71.01, 42.37}); got != 20 {
+		t.Errorf("nearestFieldElevation near KBOS = %d, want 20", got)
+	}
+
+	// Far from both: nothing within 50nm.
+	if got := nearestFieldElevation(facilities, [2]float32{-83.0, 40.0}); got != 0 {
+		t.Errorf("nearestFieldElevation far from both = %d, want 0", got)
+	}
+}
+
+func TestRecentlyDeparted(t *testing.T) {
+	fac := Facility{Majors: n90Airports()}
+	store := track.NewStore()
+	now := time.Now()
+
+	// DAL1 was on the ground at JFK a minute ago and is now airborne nearby.
+	store.Update(100, "DAL1", now.Add(-1*time.Minute), 40.641766, -73.780968, 10, 5, 13)
+	store.Update(100, "DAL1", now, 40.66, -73.79, 1500, 180, 13)
+
+	state := &VATSIMState{
+		Pilots: []Pilot{{CID: 100, Latitude: 40.66, Longitude: -73.79}},
+	}
+
+	if got := fac.recentlyDeparted(state, store, now); got != 1 {
+		t.Errorf("recentlyDeparted = %d, want 1", got)
+	}
+}
+
+func TestRecentlyDepartedIgnoresAircraftStillOnGround(t *testing.T) {
+	fac := Facility{Majors: n90Airports()}
+	store := track.NewStore()
+	now := time.Now()
+
+	store.Update(101, "DAL2", now, 40.641766, -73.780968, 10, 5, 13)
+
+	state := &VATSIMState{
+		Pilots: []Pilot{{CID: 101, Latitude: 40.641766, Longitude: -73.780968}},
+	}
+
+	if got := fac.recentlyDeparted(state, store, now); got != 0 {
+		t.Errorf("recentlyDeparted = %d, want 0 (aircraft is still on the ground)", got)
+	}
+}
+
+func TestGoArounds(t *testing.T) {
+	fac := Facility{Majors: n90Airports()}
+	store := track.NewStore()
+	now := time.Now()
+
+	// JBU45 descends toward JFK, then climbs away without ever landing.
+	alts := []int{3000, 1500, 600, 1200, 2500}
+	for i, alt := range alts {
+		store.Update(200, "JBU45", now.Add(time.Duration(i)*20*time.Second), 40.641766, -73.780968, alt, 140, 13)
+	}
+
+	state := &VATSIMState{
+		Pilots: []Pilot{{CID: 200, Latitude: 40.641766, Longitude: -73.780968}},
+	}
+
+	if got := fac.goArounds(state, store); got != 1 {
+		t.Errorf("goArounds = %d, want 1", got)
+	}
+}
+
+func TestGoAroundsIgnoresNormalArrival(t *testing.T) {
+	fac := Facility{Majors: n90Airports()}
+	store := track.NewStore()
+	now := time.Now()
+
+	// A steady descent straight to the ground, no go-around.
+	alts := []int{3000, 2000, 1000, 0}
+	for i, alt := range alts {
+		store.Update(201, "JBU46", now.Add(time.Duration(i)*20*time.Second), 40.641766, -73.780968, alt, 140, 13)
+	}
+
+	state := &VATSIMState{
+		Pilots: []Pilot{{CID: 201, Latitude: 40.641766, Longitude: -73.780968}},
+	}
+
+	if got := fac.goArounds(state, store); got != 0 {
+		t.Errorf("goArounds = %d, want 0 (normal landing)", got)
+	}
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"bytes"
-	_ "embed"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"math"
@@ -12,6 +12,10 @@ import (
 	"slices"
 	"strings"
 	"time"
+
+	"github.com/mmp/metroline/cache"
+	"github.com/mmp/metroline/metar"
+	"github.com/mmp/metroline/track"
 )
 
 type VATSIMState struct {
@@ -85,10 +89,12 @@ type ATIS struct {
 	LastUpdate time.Time `json:"last_updated"`
 }
 
-func FetchVATSIMState() (*VATSIMState, error) {
-	st, err := FetchURL("https://status.vatsim.net/status.json")
+// FetchVATSIMState retrieves the current VATSIM datafeed, along with the
+// base URL of the METAR endpoint advertised alongside it.
+func FetchVATSIMState() (*VATSIMState, string, error) {
+	st, err := FetchURLCached("https://status.vatsim.net/status.json")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	var status struct {
@@ -99,26 +105,45 @@ func FetchVATSIMState() (*VATSIMState, error) {
 		Metar []string `json:"metar"`
 	}
 	if err := json.Unmarshal(st, &status); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if len(status.Data.V3) != 1 || len(status.Metar) != 1 {
-		return nil, fmt.Errorf("Unexpected response format: %s -> %+v\n", string(st), status)
+		return nil, "", fmt.Errorf("Unexpected response format: %s -> %+v\n", string(st), status)
 	}
 
-	var state VATSIMState
-	st, err = FetchURL(status.Data.V3[0])
+	st, err = FetchURLCached(status.Data.V3[0])
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	// The datafeed embeds its own last-update timestamp; if it matches
+	// the one we parsed last time, the (expensive, 1500+ pilot) JSON
+	// unmarshal below can be skipped in favor of the state we already
+	// decoded then.
+	var general struct {
+		General struct {
+			Update string `json:"update"`
+		} `json:"general"`
+	}
+	if err := json.Unmarshal(st, &general); err != nil {
+		return nil, "", err
+	}
+
+	var lastUpdate string
+	var state VATSIMState
+	if cache.LoadValue(status.Data.V3[0]+".update", &lastUpdate) && lastUpdate == general.General.Update &&
+		cache.LoadValue(status.Data.V3[0]+".state", &state) {
+		return &state, status.Metar[0], nil
 	}
 
 	if err := json.Unmarshal(st, &state); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return &state, nil
-}
+	_ = cache.SaveValue(status.Data.V3[0]+".update", general.General.Update)
+	_ = cache.SaveValue(status.Data.V3[0]+".state", state)
 
-//go:embed ZNY-positions.json
-var znyPositionConfigJSON []byte
+	return &state, status.Metar[0], nil
+}
 
 type Position struct {
 	Name       string  `json:"callsign"` // e.g. JFK_TWR
@@ -134,110 +159,152 @@ type Position struct {
 	} `json:"starsConfiguration"`
 }
 
-// convert -resize 20x20 ~/Downloads/ZNY-Mediakit/ZNY-transparent-black-1000x1000px.png zny.png
-//
-//go:embed zny.png
-var znyPNG []byte
-
 type Airport struct {
-	Name     string
-	Location [2]float32
+	Name        string
+	Location    [2]float32
+	ElevationFt int // field elevation, MSL; used to derive ground state from altitude
 }
 
 func (a Airport) DistanceTo(p [2]float32) float32 {
 	return NMDistance2LL(a.Location, p)
 }
 
+// bbox is a lat/long bounding box, used as a cheap prefilter before
+// falling back to the more expensive Haversine distance in
+// NMDistance2LL. One degree of latitude is ~60nm everywhere; a degree of
+// longitude shrinks by cos(latitude).
+type bbox struct {
+	minLat, maxLat float32
+	minLon, maxLon float32
+}
+
+func boxAround(center [2]float32, radiusNM float32) bbox {
+	latDelta := radiusNM / 60
+	lonDelta := radiusNM / (60 * float32(math.Cos(float64(center[1])*math.Pi/180)))
+	return bbox{
+		minLat: center[1] - latDelta, maxLat: center[1] + latDelta,
+		minLon: center[0] - lonDelta, maxLon: center[0] + lonDelta,
+	}
+}
+
+func (b bbox) contains(p [2]float32) bool {
+	return p[1] >= b.minLat && p[1] <= b.maxLat && p[0] >= b.minLon && p[0] <= b.maxLon
+}
+
+// within reports whether p is within radiusNM of the airport, using box
+// (precomputed via boxAround for the same radius) to avoid the Haversine
+// calculation in NMDistance2LL for airports that aren't even close.
+func (a Airport) within(box bbox, p [2]float32, radiusNM float32) bool {
+	return box.contains(p) && a.DistanceTo(p) < radiusNM
+}
+
 type MajorAirport struct {
 	Airport
 	Satellites []Airport
 }
 
 func main() {
-	state, err := FetchVATSIMState()
+	configPath := flag.String("config", "", "path to a facility config file (JSON array of facilities)")
+	serveAddr := flag.String("serve", "", "if set, run a long-lived server on this address (e.g. :8080) exposing /metrics and /api/v1/* instead of printing xbar output once")
+	interval := flag.Duration("interval", 30*time.Second, "VATSIM datafeed refresh interval in -serve mode")
+	flag.Parse()
+	if *configPath == "" {
+		panic("metroline: -config is required")
+	}
+
+	facilities, err := LoadFacilities(*configPath)
 	if err != nil {
 		panic(err)
 	}
+	if len(facilities) == 0 {
+		panic("metroline: config defines no facilities")
+	}
+
+	if *serveAddr != "" {
+		if err := RunServer(*serveAddr, facilities, *interval); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-	// Filter down to the traffic we're interested in reporting.
-	// Majors and their sats.
-	n90 := []MajorAirport{
-		MajorAirport{
-			Airport: Airport{
-				Name:     "KJFK",
-				Location: [2]float32{-73.780968, 40.641766},
-			},
-			Satellites: []Airport{
-				Airport{Name: "KFRG", Location: [2]float32{-73.4134208, 40.7292742}},
-				Airport{Name: "KISP", Location: [2]float32{-73.1006651, 40.7961357}},
-				Airport{Name: "KOXC", Location: [2]float32{-73.1351825, 41.4782806}},
-				Airport{Name: "KFOK", Location: [2]float32{-72.6318119, 40.8436186}},
-				Airport{Name: "KBDR", Location: [2]float32{-73.1261758, 41.1634808}},
-				Airport{Name: "KHVN", Location: [2]float32{-72.8877292, 41.2637247}},
-			},
-		},
-		MajorAirport{
-			Airport: Airport{
-				Name:     "KLGA",
-				Location: [2]float32{-73.87261, 40.77724},
-			},
-			Satellites: []Airport{
-				Airport{Name: "KDXR", Location: [2]float32{-73.4821894, 41.3715344}},
-				Airport{Name: "KHPN", Location: [2]float32{-73.7075661, 41.0669531}},
-			},
-		},
-		MajorAirport{
-			Airport: Airport{
-				Name:     "KEWR",
-				Location: [2]float32{-74.174538, 40.689491},
-			},
-			Satellites: []Airport{
-				Airport{Name: "KTEB", Location: [2]float32{-74.0608333, 40.8501111}},
-				Airport{Name: "KCDW", Location: [2]float32{-74.2813503, 40.8752247}},
-				Airport{Name: "KMMU", Location: [2]float32{-74.4148886, 40.7993383}},
-			},
-		},
-	}
-	n90dep, n90arr, n90count := CountTraffic(state, n90)
-
-	// Get the online controllers
-	var znyPositions []Position
-	dec := json.NewDecoder(bytes.NewReader(znyPositionConfigJSON))
-	if err := dec.Decode(&znyPositions); err != nil {
+	state, metarURL, err := FetchVATSIMState()
+	if err != nil {
 		panic(err)
 	}
-	online := ActiveControllers(state, znyPositions)
-	ctr := slices.ContainsFunc(online, func(c Controller) bool { return strings.HasSuffix(c.Callsign, "_CTR") })
+
+	var stations []string
+	for _, fac := range facilities {
+		for _, major := range fac.Majors {
+			stations = append(stations, major.Name)
+		}
+	}
+	metars, err := metar.FetchAll(metarURL, stations)
+	if err != nil {
+		// Traffic counts are more important than weather; don't fail the
+		// whole report over a METAR fetch hiccup.
+		metars = nil
+	}
+
+	now := time.Now()
+	tracks := track.Load()
+	UpdateAllTracks(state, facilities, tracks, now)
+
+	var totalOnline, totalTraffic int
+	var anyCtrOnline bool
+	var bodies []string
+	for _, fac := range facilities {
+		online, ctrOnline, traffic, body := fac.Report(state, metars, tracks, now)
+		totalOnline += online
+		totalTraffic += traffic
+		anyCtrOnline = anyCtrOnline || ctrOnline
+		bodies = append(bodies, body)
+	}
+
+	tracks.Prune(now, track.StaleAfter)
+	// The next run can rebuild history from scratch; don't fail the
+	// report over a cache write hiccup.
+	_ = tracks.Save()
 
 	// Print it out, per https://github.com/matryer/xbar-plugins/blob/main/CONTRIBUTING.md
-	fmt.Printf("%d", len(online))
-	if ctr {
+	fmt.Printf("%d", totalOnline)
+	if anyCtrOnline {
 		fmt.Printf("*")
 	}
-	fmt.Printf(":headphones: %d :airplane: | templateImage=%s", n90count, Base64(znyPNG))
+	fmt.Printf(":headphones: %d :airplane:", totalTraffic)
+	if icon := facilities[0].Icon; len(icon) > 0 {
+		fmt.Printf(" | templateImage=%s", Base64(icon))
+	}
 	fmt.Printf("\n")
 
-	// Controllers
-	if len(online) > 0 {
-		fmt.Printf("---\n")
-
-		for _, ctrl := range online {
-			s := time.Since(ctrl.Logon)
-			h, m := int(s.Hours()), int(s.Minutes())-60*int(s.Hours())
-			fmt.Printf("%-9s %s (%d:%02d) | font=Monaco | href=https://nyartcc.org/controller/%d\n",
-				ctrl.Callsign, ctrl.Name, h, m, ctrl.CID)
-		}
+	for _, body := range bodies {
+		fmt.Printf("---\n%s", body)
 	}
+}
 
-	// Traffic
-	fmt.Printf("---\n")
-	for _, major := range n90 {
-		fmt.Printf("%s %2dðŸ›« %2dðŸ›¬ | font=Monaco | href=https://vatsim-radar.com/airport/%s\n", major.Name,
-			n90dep[major.Name], n90arr[major.Name], major.Name)
+// formatMetar renders an observation's wind, visibility, altimeter and
+// flight category for display in an xbar submenu row.
+func formatMetar(o metar.Observation) string {
+	wind := fmt.Sprintf("%03d°@%dkt", o.WindDir, o.WindSpeed)
+	if o.WindDir < 0 {
+		wind = fmt.Sprintf("VRB@%dkt", o.WindSpeed)
 	}
+	if o.WindGust > 0 {
+		wind += fmt.Sprintf("G%d", o.WindGust)
+	}
+
+	return fmt.Sprintf("%s | %gSM | %.2fin | %s", wind, o.VisibilitySM, o.Altimeter, o.Category())
 }
 
-func CountTraffic(state *VATSIMState, airports []MajorAirport) (map[string]int, map[string]int, int) {
+// CountTraffic counts departures and arrivals at airports from state. store
+// is consulted (when non-nil) to tell a taxiing departure apart from an
+// arrival that just landed and is still taxiing in — see isTaxiingOut —
+// falling back to a raw-groundspeed proxy for aircraft it hasn't tracked
+// yet.
+func CountTraffic(state *VATSIMState, airports []MajorAirport, store *track.Store) (map[string]int, map[string]int, int) {
+	if len(airports) == 0 {
+		return map[string]int{}, map[string]int{}, 0
+	}
+
 	major := func(ap string) *Airport { // return corresponding major
 		for _, major := range airports {
 			if major.Name == ap {
@@ -252,6 +319,23 @@ func CountTraffic(state *VATSIMState, airports []MajorAirport) (map[string]int,
 		return nil
 	}
 
+	// Precompute the bounding boxes for each radius we test against below,
+	// once per airport rather than once per pilot.
+	type radii struct{ dep30, arr300, ground3 bbox }
+	boxes := make(map[string]radii, len(airports))
+	satGround3 := make(map[string]bbox)
+	for _, m := range airports {
+		boxes[m.Name] = radii{
+			dep30:   boxAround(m.Location, 30),
+			arr300:  boxAround(m.Location, 300),
+			ground3: boxAround(m.Location, 3),
+		}
+		for _, sat := range m.Satellites {
+			satGround3[sat.Name] = boxAround(sat.Location, 3)
+		}
+	}
+	coarseBox := boxAround(airports[0].Location, 500)
+
 	dep, arr := make(map[string]int), make(map[string]int)
 	count := 0
 	for _, pilot := range state.Pilots {
@@ -259,26 +343,26 @@ func CountTraffic(state *VATSIMState, airports []MajorAirport) (map[string]int,
 
 		// If it's >500nm from the first major (whatever it is), don't
 		// consider it further.
-		if airports[0].DistanceTo(pilotLoc) > 500 {
+		if !airports[0].within(coarseBox, pilotLoc, 500) {
 			continue
 		}
 
 		// Count departures that are within 10 miles of the departure field
-		if major := major(pilot.FlightPlan.Departure); major != nil && major.DistanceTo(pilotLoc) < 30 {
+		if major := major(pilot.FlightPlan.Departure); major != nil && major.within(boxes[major.Name].dep30, pilotLoc, 30) {
 			dep[major.Name] = dep[major.Name] + 1
 			count++
-		} else if pilot.Groundspeed < 20 && pilot.FlightPlan.Departure == "" {
+		} else if pilot.FlightPlan.Departure == "" && isTaxiingOut(store, pilot) {
 		loop:
 			// Look for aircraft without a flight plan on the ground at one of the airports.
 			for _, major := range airports {
-				if major.DistanceTo(pilotLoc) < 3 {
+				if major.within(boxes[major.Name].ground3, pilotLoc, 3) {
 					dep[major.Name] = dep[major.Name] + 1
 					count++
 					break
 				}
 
 				for _, sat := range major.Satellites {
-					if sat.DistanceTo(pilotLoc) < 3 {
+					if sat.within(satGround3[sat.Name], pilotLoc, 3) {
 						dep[major.Name] = dep[major.Name] + 1
 						count++
 						break loop
@@ -288,7 +372,7 @@ func CountTraffic(state *VATSIMState, airports []MajorAirport) (map[string]int,
 		}
 
 		// Arrivals within 300 miles but must also be moving
-		if major := major(pilot.FlightPlan.Arrival); major != nil && major.DistanceTo(pilotLoc) < 300 && pilot.Groundspeed > 20 {
+		if major := major(pilot.FlightPlan.Arrival); major != nil && major.within(boxes[major.Name].arr300, pilotLoc, 300) && pilot.Groundspeed > 20 {
 			arr[major.Name] = arr[major.Name] + 1
 			count++
 		}
@@ -297,6 +381,28 @@ func CountTraffic(state *VATSIMState, airports []MajorAirport) (map[string]int,
 	return dep, arr, count
 }
 
+// isTaxiingOut reports whether a flight-planless pilot on the ground
+// looks like a departure taxiing out, as opposed to an arrival that just
+// landed and is still taxiing in. Without track history to go on (store
+// nil, or the aircraft hasn't been seen before), it falls back to the
+// raw-groundspeed proxy the heuristic used before track history existed.
+func isTaxiingOut(store *track.Store, pilot Pilot) bool {
+	if store == nil {
+		return pilot.Groundspeed < 20
+	}
+	ac, ok := store.Aircraft[pilot.CID]
+	if !ok {
+		return pilot.Groundspeed < 20
+	}
+	if !ac.OnGround {
+		return false
+	}
+	// If the oldest position still on file was moving fast, this
+	// aircraft was airborne a moment ago and is taxiing in after
+	// landing, not taxiing out for departure.
+	return len(ac.Positions) == 0 || ac.Positions[0].GroundspeedKt < 40
+}
+
 func ActiveControllers(state *VATSIMState, positions []Position) []Controller {
 	var online []Controller
 	for _, ctrl := range state.Controllers {
@@ -325,6 +431,56 @@ func FetchURL(url string) ([]byte, error) {
 	return text, nil
 }
 
+// FetchURLCached is like FetchURL, but consults an on-disk cache
+// (cache.HTTPEntry) keyed by url and makes a conditional GET using its
+// ETag/Last-Modified, if any. A 304 Not Modified response returns the
+// cached body without re-downloading it.
+func FetchURLCached(url string) ([]byte, error) {
+	prior := cache.LoadHTTP(url)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if prior != nil {
+		if prior.ETag != "" {
+			req.Header.Set("If-None-Match", prior.ETag)
+		}
+		if prior.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prior.LastModified)
+		}
+	}
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified && prior != nil {
+		return prior.Body, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", url, response.Status)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := cache.HTTPEntry{
+		Body:         body,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}
+	// The cache is a pure optimization; a write failure shouldn't stop us
+	// from returning the data we just fetched.
+	_ = cache.SaveHTTP(url, entry)
+
+	return body, nil
+}
+
 func Base64(b []byte) string {
 	var buf bytes.Buffer
 	enc := base64.NewEncoder(base64.StdEncoding, &buf)
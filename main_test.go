@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func n90Airports() []MajorAirport {
+	return []MajorAirport{
+		{
+			Airport: Airport{Name: "KJFK", Location: [2]float32{-73.780968, 40.641766}},
+			Satellites: []Airport{
+				{Name: "KFRG", Location: [2]float32{-73.4134208, 40.7292742}},
+				{Name: "KISP", Location: [2]float32{-73.1006651, 40.7961357}},
+			},
+		},
+		{
+			Airport: Airport{Name: "KLGA", Location: [2]float32{-73.87261, 40.77724}},
+			Satellites: []Airport{
+				{Name: "KHPN", Location: [2]float32{-73.7075661, 41.0669531}},
+			},
+		},
+		{
+			Airport: Airport{Name: "KEWR", Location: [2]float32{-74.174538, 40.689491}},
+			Satellites: []Airport{
+				{Name: "KTEB", Location: [2]float32{-74.0608333, 40.8501111}},
+			},
+		},
+	}
+}
+
+func TestCountTraffic(t *testing.T) {
+	airports := n90Airports()
+	state := &VATSIMState{
+		Pilots: []Pilot{
+			// On final into JFK, should count as an arrival.
+			{CID: 1, Latitude: 40.7, Longitude: -73.8, Groundspeed: 150},
+			// Taxiing out at JFK with no flight plan yet.
+			{CID: 2, Latitude: 40.641766, Longitude: -73.780968, Groundspeed: 5},
+			// Over Ohio, ~420nm out: inside the 500nm coarse filter but
+			// beyond the 300nm arrival radius.
+			{CID: 3, Latitude: 40.0, Longitude: -83.0, Groundspeed: 400},
+		},
+	}
+	state.Pilots[0].FlightPlan.Arrival = "KJFK"
+	state.Pilots[2].FlightPlan.Arrival = "KJFK"
+
+	dep, arr, count := CountTraffic(state, airports, nil)
+	if arr["KJFK"] != 1 {
+		t.Errorf("arr[KJFK] = %d, want 1", arr["KJFK"])
+	}
+	if dep["KJFK"] != 1 {
+		t.Errorf("dep[KJFK] = %d, want 1", dep["KJFK"])
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+}
+
+// loadSnapshotFixture reads the anonymized VATSIM v3 datafeed snapshot
+// checked into testdata/, so CountTraffic is benchmarked against
+// traffic shaped like a real datafeed (clustered near major airports,
+// with a realistic mix of ground/climb/descent/enroute phases) rather
+// than uniform random noise.
+func loadSnapshotFixture(b *testing.B) *VATSIMState {
+	b.Helper()
+	data, err := os.ReadFile("testdata/vatsim_snapshot.json")
+	if err != nil {
+		b.Fatalf("reading fixture: %v", err)
+	}
+	var state VATSIMState
+	if err := json.Unmarshal(data, &state); err != nil {
+		b.Fatalf("unmarshaling fixture: %v", err)
+	}
+	return &state
+}
+
+// countTrafficNaive is CountTraffic as it looked before chunk0-4 added
+// the bounding-box prefilter: identical logic, but every distance check
+// goes straight to the Haversine NMDistance2LL. Kept around so
+// BenchmarkCountTrafficNaive can show the prefilter's actual speedup
+// instead of only measuring the already-optimized path.
+func countTrafficNaive(state *VATSIMState, airports []MajorAirport) (map[string]int, map[string]int, int) {
+	if len(airports) == 0 {
+		return map[string]int{}, map[string]int{}, 0
+	}
+
+	major := func(ap string) *Airport {
+		for _, major := range airports {
+			if major.Name == ap {
+				return &major.Airport
+			}
+			for _, sat := range major.Satellites {
+				if sat.Name == ap {
+					return &major.Airport
+				}
+			}
+		}
+		return nil
+	}
+
+	dep, arr := make(map[string]int), make(map[string]int)
+	count := 0
+	for _, pilot := range state.Pilots {
+		pilotLoc := [2]float32{pilot.Longitude, pilot.Latitude}
+
+		if airports[0].DistanceTo(pilotLoc) > 500 {
+			continue
+		}
+
+		if major := major(pilot.FlightPlan.Departure); major != nil && major.DistanceTo(pilotLoc) < 30 {
+			dep[major.Name] = dep[major.Name] + 1
+			count++
+		} else if pilot.FlightPlan.Departure == "" && pilot.Groundspeed < 20 {
+		loop:
+			for _, major := range airports {
+				if major.DistanceTo(pilotLoc) < 3 {
+					dep[major.Name] = dep[major.Name] + 1
+					count++
+					break
+				}
+				for _, sat := range major.Satellites {
+					if sat.DistanceTo(pilotLoc) < 3 {
+						dep[major.Name] = dep[major.Name] + 1
+						count++
+						break loop
+					}
+				}
+			}
+		}
+
+		if major := major(pilot.FlightPlan.Arrival); major != nil && major.DistanceTo(pilotLoc) < 300 && pilot.Groundspeed > 20 {
+			arr[major.Name] = arr[major.Name] + 1
+			count++
+		}
+	}
+
+	return dep, arr, count
+}
+
+func TestFetchURLCachedUsesCachedBodyOn304(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("first response"))
+	}))
+	defer srv.Close()
+
+	body, err := FetchURLCached(srv.URL)
+	if err != nil {
+		t.Fatalf("FetchURLCached (first fetch): %v", err)
+	}
+	if string(body) != "first response" {
+		t.Errorf("first fetch body = %q, want %q", body, "first response")
+	}
+
+	body, err = FetchURLCached(srv.URL)
+	if err != nil {
+		t.Fatalf("FetchURLCached (conditional fetch): %v", err)
+	}
+	if string(body) != "first response" {
+		t.Errorf("conditional fetch body = %q, want cached %q", body, "first response")
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2 (one unconditional, one conditional)", requests)
+	}
+}
+
+func TestFetchURLCachedRejectsErrorStatus(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if _, err := FetchURLCached(srv.URL); err == nil {
+		t.Error("FetchURLCached on a 500 response: expected an error, got nil")
+	}
+}
+
+func BenchmarkCountTraffic(b *testing.B) {
+	state := loadSnapshotFixture(b)
+	airports := n90Airports()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CountTraffic(state, airports, nil)
+	}
+}
+
+// BenchmarkCountTrafficNaive runs the same fixture through
+// countTrafficNaive, so `go test -bench CountTraffic` shows the bbox
+// prefilter's actual speedup alongside BenchmarkCountTraffic.
+func BenchmarkCountTrafficNaive(b *testing.B) {
+	state := loadSnapshotFixture(b)
+	airports := n90Airports()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		countTrafficNaive(state, airports)
+	}
+}
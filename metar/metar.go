@@ -0,0 +1,270 @@
+// Package metar parses METAR surface weather observations and derives
+// FAA flight categories (VFR/MVFR/IFR/LIFR) from them.
+package metar
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// FlightCategory is the FAA ceiling/visibility classification of an
+// Observation.
+type FlightCategory int
+
+const (
+	Unknown FlightCategory = iota
+	VFR
+	MVFR
+	IFR
+	LIFR
+)
+
+func (c FlightCategory) String() string {
+	switch c {
+	case VFR:
+		return "VFR"
+	case MVFR:
+		return "MVFR"
+	case IFR:
+		return "IFR"
+	case LIFR:
+		return "LIFR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Observation is the subset of a METAR report that metroline cares about.
+type Observation struct {
+	Station      string
+	Raw          string
+	WindDir      int // degrees true; -1 if variable (VRB)
+	WindSpeed    int // knots
+	WindGust     int // knots; 0 if not gusting
+	VisibilitySM float64
+	// CeilingFt is the height AGL of the lowest broken/overcast layer (or
+	// vertical visibility), in feet. -1 means no ceiling was reported
+	// (sky clear, or only FEW/SCT layers).
+	CeilingFt int
+	Altimeter float64 // inches of mercury; 0 if not reported
+}
+
+// Category classifies the observation per the standard FAA
+// ceiling/visibility flight category rules, taking the worse of the two.
+func (o Observation) Category() FlightCategory {
+	return Classify(o.CeilingFt, o.VisibilitySM)
+}
+
+// Classify derives a FlightCategory from a ceiling (feet AGL, -1 if none)
+// and a visibility (statute miles).
+func Classify(ceilingFt int, visibilitySM float64) FlightCategory {
+	cat := VFR
+	if ceilingFt >= 0 {
+		switch {
+		case ceilingFt < 500:
+			cat = LIFR
+		case ceilingFt < 1000:
+			cat = IFR
+		case ceilingFt < 3000:
+			cat = MVFR
+		}
+	}
+
+	switch {
+	case visibilitySM < 1:
+		if cat < LIFR {
+			cat = LIFR
+		}
+	case visibilitySM < 3:
+		if cat < IFR {
+			cat = IFR
+		}
+	case visibilitySM < 5:
+		if cat < MVFR {
+			cat = MVFR
+		}
+	}
+
+	return cat
+}
+
+// Parse decodes a single raw METAR line into an Observation. It only
+// extracts the fields metroline displays; unrecognized tokens are
+// ignored.
+func Parse(raw string) (Observation, error) {
+	raw = strings.TrimSpace(raw)
+	fields := strings.Fields(raw)
+	if len(fields) < 2 {
+		return Observation{}, fmt.Errorf("metar: too few fields: %q", raw)
+	}
+
+	o := Observation{
+		Station:   fields[0],
+		Raw:       raw,
+		CeilingFt: -1,
+	}
+
+	for i := 1; i < len(fields); i++ {
+		f := fields[i]
+		switch {
+		case strings.HasSuffix(f, "KT") && (len(f) >= 3 && isDigits(f[:3]) || strings.HasPrefix(f, "VRB")):
+			parseWind(f, &o)
+
+		case strings.HasSuffix(f, "SM"):
+			parseVisibility(f, &o)
+
+		case isDigits(f) && i+1 < len(fields) && isFractionalSM(fields[i+1]):
+			// Whole-mile part of a split "N N/DSM" visibility, e.g. the
+			// "1" in "1 1/2SM".
+			if whole, err := strconv.Atoi(f); err == nil {
+				o.VisibilitySM += float64(whole)
+			}
+
+		case strings.HasPrefix(f, "BKN") || strings.HasPrefix(f, "OVC") || strings.HasPrefix(f, "VV"):
+			parseCeiling(f, &o)
+
+		case len(f) == 5 && f[0] == 'A' && isDigits(f[1:]):
+			hundredths, err := strconv.Atoi(f[1:])
+			if err == nil {
+				o.Altimeter = float64(hundredths) / 100
+			}
+		}
+	}
+
+	return o, nil
+}
+
+// isFractionalSM reports whether f is a "N/DSM" visibility fraction,
+// e.g. "1/2SM".
+func isFractionalSM(f string) bool {
+	body := strings.TrimSuffix(f, "SM")
+	if !strings.HasSuffix(f, "SM") {
+		return false
+	}
+	slash := strings.IndexByte(body, '/')
+	return slash > 0 && isDigits(body[:slash]) && isDigits(body[slash+1:])
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseWind handles dddffKT, dddffGggKT and VRBffKT.
+func parseWind(f string, o *Observation) {
+	body := strings.TrimSuffix(f, "KT")
+
+	if strings.HasPrefix(body, "VRB") {
+		o.WindDir = -1
+		body = body[3:]
+	} else if len(body) >= 5 {
+		dir, err := strconv.Atoi(body[:3])
+		if err != nil {
+			return
+		}
+		o.WindDir = dir
+		body = body[3:]
+	} else {
+		return
+	}
+
+	if g := strings.IndexByte(body, 'G'); g >= 0 {
+		speed, err1 := strconv.Atoi(body[:g])
+		gust, err2 := strconv.Atoi(body[g+1:])
+		if err1 == nil && err2 == nil {
+			o.WindSpeed, o.WindGust = speed, gust
+		}
+	} else if speed, err := strconv.Atoi(body); err == nil {
+		o.WindSpeed = speed
+	}
+}
+
+// parseVisibility handles the "NSM" and "N/DSM" tokens of a visibility
+// group. The whole-mile part of a split "N N/DSM" group (e.g. the "1" in
+// "1 1/2SM") is a separate field and is added in by the caller's loop.
+func parseVisibility(f string, o *Observation) {
+	body := strings.TrimSuffix(f, "SM")
+	body = strings.TrimPrefix(body, "P") // e.g. P6SM (visibility greater than)
+	body = strings.TrimPrefix(body, "M") // e.g. M1/4SM (visibility less than)
+
+	if slash := strings.IndexByte(body, '/'); slash >= 0 {
+		num, err1 := strconv.Atoi(body[:slash])
+		den, err2 := strconv.Atoi(body[slash+1:])
+		if err1 == nil && err2 == nil && den != 0 {
+			o.VisibilitySM += float64(num) / float64(den)
+		}
+		return
+	}
+
+	if whole, err := strconv.Atoi(body); err == nil {
+		o.VisibilitySM += float64(whole)
+	}
+}
+
+// parseCeiling handles BKNnnn, OVCnnn and VVnnn, tracking the lowest
+// ceiling layer seen.
+func parseCeiling(f string, o *Observation) {
+	var heightStr string
+	switch {
+	case strings.HasPrefix(f, "BKN"):
+		heightStr = f[3:]
+	case strings.HasPrefix(f, "OVC"):
+		heightStr = f[3:]
+	case strings.HasPrefix(f, "VV"):
+		heightStr = f[2:]
+	}
+	if len(heightStr) < 3 || !isDigits(heightStr[:3]) {
+		return
+	}
+	hundreds, err := strconv.Atoi(heightStr[:3])
+	if err != nil {
+		return
+	}
+	ft := hundreds * 100
+	if o.CeilingFt < 0 || ft < o.CeilingFt {
+		o.CeilingFt = ft
+	}
+}
+
+// FetchAll retrieves raw METARs for the given station IDs from the
+// VATSIM METAR endpoint (url, as reported in status.json) and parses
+// each returned line.
+func FetchAll(url string, stations []string) (map[string]Observation, error) {
+	req := url + "?id=" + strings.Join(stations, ",")
+
+	resp, err := http.Get(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	observations := make(map[string]Observation)
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		o, err := Parse(line)
+		if err != nil {
+			continue
+		}
+		observations[o.Station] = o
+	}
+
+	return observations, nil
+}
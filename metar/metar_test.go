@@ -0,0 +1,77 @@
+package metar
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Observation
+	}{
+		{
+			// Clear skies, good visibility: VFR.
+			raw: "KJFK 291951Z 28014G21KT 10SM FEW250 24/12 A3005",
+			want: Observation{
+				Station: "KJFK", WindDir: 280, WindSpeed: 14, WindGust: 21,
+				VisibilitySM: 10, CeilingFt: -1, Altimeter: 30.05,
+			},
+		},
+		{
+			// Overcast ceiling at 800ft: IFR by ceiling.
+			raw: "KLGA 291951Z 31008KT 3SM BR OVC008 12/10 A3012",
+			want: Observation{
+				Station: "KLGA", WindDir: 310, WindSpeed: 8,
+				VisibilitySM: 3, CeilingFt: 800, Altimeter: 30.12,
+			},
+		},
+		{
+			// Low visibility with a fraction: LIFR by visibility.
+			raw: "KEWR 291951Z VRB03KT 1/2SM FG VV002 08/08 A2998",
+			want: Observation{
+				Station: "KEWR", WindDir: -1, WindSpeed: 3,
+				VisibilitySM: 0.5, CeilingFt: 200, Altimeter: 29.98,
+			},
+		},
+		{
+			// Mixed whole + fractional visibility, broken ceiling: IFR by
+			// visibility.
+			raw: "KBDR 291951Z 09015G25KT 1 1/2SM BKN035 20/14 A2990",
+			want: Observation{
+				Station: "KBDR", WindDir: 90, WindSpeed: 15, WindGust: 25,
+				VisibilitySM: 1.5, CeilingFt: 3500, Altimeter: 29.90,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		got, err := Parse(tc.raw)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", tc.raw, err)
+		}
+		tc.want.Raw = got.Raw // not worth repeating in every case
+		if got != tc.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		ceilingFt    int
+		visibilitySM float64
+		want         FlightCategory
+	}{
+		{-1, 10, VFR},
+		{4000, 6, VFR},
+		{3500, 1.5, IFR}, // visibility is the limiting factor
+		{2500, 5, MVFR},
+		{800, 10, IFR},
+		{200, 10, LIFR},
+		{5000, 0.5, LIFR},
+	}
+
+	for _, tc := range tests {
+		if got := Classify(tc.ceilingFt, tc.visibilitySM); got != tc.want {
+			t.Errorf("Classify(%d, %v) = %v, want %v", tc.ceilingFt, tc.visibilitySM, got, tc.want)
+		}
+	}
+}
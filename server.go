@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mmp/metroline/track"
+)
+
+// serverSnapshot is the latest refreshed state exposed by -serve mode's
+// HTTP endpoints.
+type serverSnapshot struct {
+	Stats         []FacilityStats
+	FetchDuration time.Duration
+	FetchedAt     time.Time
+}
+
+// RunServer runs metroline in long-lived server mode: it refreshes the
+// VATSIM datafeed every interval and exposes /metrics (Prometheus text
+// exposition format), /api/v1/traffic and /api/v1/controllers over
+// HTTP. It only returns once ListenAndServe does.
+func RunServer(addr string, facilities []Facility, interval time.Duration) error {
+	var mu sync.RWMutex
+	var snap serverSnapshot
+
+	refresh := func() {
+		start := time.Now()
+		state, _, err := FetchVATSIMState()
+		duration := time.Since(start)
+		if err != nil {
+			log.Printf("metroline: refresh failed: %v", err)
+			return
+		}
+
+		now := time.Now()
+		store := track.Load()
+		UpdateAllTracks(state, facilities, store, now)
+		stats := make([]FacilityStats, len(facilities))
+		for i, fac := range facilities {
+			stats[i] = fac.Stats(state, store, now)
+		}
+		store.Prune(now, track.StaleAfter)
+		_ = store.Save()
+
+		mu.Lock()
+		snap = serverSnapshot{Stats: stats, FetchDuration: duration, FetchedAt: now}
+		mu.Unlock()
+	}
+
+	refresh()
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			refresh()
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		defer mu.RUnlock()
+		writeMetrics(w, snap)
+	})
+	mux.HandleFunc("/api/v1/traffic", func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		defer mu.RUnlock()
+		writeTrafficJSON(w, snap)
+	})
+	mux.HandleFunc("/api/v1/controllers", func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		defer mu.RUnlock()
+		writeControllersJSON(w, snap)
+	})
+
+	log.Printf("metroline: serving on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// writeMetrics renders snap as Prometheus text exposition format.
+func writeMetrics(w http.ResponseWriter, snap serverSnapshot) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP metroline_departures Current departure count for an airport.\n")
+	fmt.Fprintf(w, "# TYPE metroline_departures gauge\n")
+	for _, stats := range snap.Stats {
+		for _, airport := range sortedKeys(stats.Departures) {
+			fmt.Fprintf(w, "metroline_departures{airport=%q} %d\n", airport, stats.Departures[airport])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP metroline_arrivals Current arrival count for an airport.\n")
+	fmt.Fprintf(w, "# TYPE metroline_arrivals gauge\n")
+	for _, stats := range snap.Stats {
+		for _, airport := range sortedKeys(stats.Arrivals) {
+			fmt.Fprintf(w, "metroline_arrivals{airport=%q} %d\n", airport, stats.Arrivals[airport])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP metroline_controllers_online Whether a position is currently staffed.\n")
+	fmt.Fprintf(w, "# TYPE metroline_controllers_online gauge\n")
+	for _, stats := range snap.Stats {
+		for _, ctrl := range stats.ActiveControllers {
+			fmt.Fprintf(w, "metroline_controllers_online{facility=%q,position=%q} 1\n", stats.Name, ctrl.Callsign)
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP metroline_recently_departed Aircraft that were on the ground and are now airborne nearby.\n")
+	fmt.Fprintf(w, "# TYPE metroline_recently_departed gauge\n")
+	for _, stats := range snap.Stats {
+		fmt.Fprintf(w, "metroline_recently_departed{facility=%q} %d\n", stats.Name, stats.RecentlyDeparted)
+	}
+
+	fmt.Fprintf(w, "# HELP metroline_go_arounds Aircraft whose recent track shows a go-around.\n")
+	fmt.Fprintf(w, "# TYPE metroline_go_arounds gauge\n")
+	for _, stats := range snap.Stats {
+		fmt.Fprintf(w, "metroline_go_arounds{facility=%q} %d\n", stats.Name, stats.GoArounds)
+	}
+
+	fmt.Fprintf(w, "# HELP metroline_vatsim_fetch_duration_seconds Time taken to fetch and parse the VATSIM datafeed.\n")
+	fmt.Fprintf(w, "# TYPE metroline_vatsim_fetch_duration_seconds gauge\n")
+	fmt.Fprintf(w, "metroline_vatsim_fetch_duration_seconds %f\n", snap.FetchDuration.Seconds())
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type trafficEntry struct {
+	Facility   string `json:"facility"`
+	Airport    string `json:"airport"`
+	Departures int    `json:"departures"`
+	Arrivals   int    `json:"arrivals"`
+}
+
+func writeTrafficJSON(w http.ResponseWriter, snap serverSnapshot) {
+	var entries []trafficEntry
+	for _, stats := range snap.Stats {
+		airports := make(map[string]bool)
+		for a := range stats.Departures {
+			airports[a] = true
+		}
+		for a := range stats.Arrivals {
+			airports[a] = true
+		}
+
+		names := make([]string, 0, len(airports))
+		for a := range airports {
+			names = append(names, a)
+		}
+		sort.Strings(names)
+
+		for _, a := range names {
+			entries = append(entries, trafficEntry{
+				Facility: stats.Name, Airport: a,
+				Departures: stats.Departures[a], Arrivals: stats.Arrivals[a],
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+type controllerEntry struct {
+	Facility string `json:"facility"`
+	Position string `json:"position"`
+	Name     string `json:"name"`
+	CID      int    `json:"cid"`
+}
+
+func writeControllersJSON(w http.ResponseWriter, snap serverSnapshot) {
+	var entries []controllerEntry
+	for _, stats := range snap.Stats {
+		for _, ctrl := range stats.ActiveControllers {
+			entries = append(entries, controllerEntry{
+				Facility: stats.Name, Position: ctrl.Callsign, Name: ctrl.Name, CID: ctrl.CID,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSnapshot() serverSnapshot {
+	return serverSnapshot{
+		Stats: []FacilityStats{
+			{
+				Name:             "N90",
+				Departures:       map[string]int{"KJFK": 3},
+				Arrivals:         map[string]int{"KJFK": 2},
+				Traffic:          5,
+				RecentlyDeparted: 1,
+				GoArounds:        2,
+				ActiveControllers: []Controller{
+					{Callsign: "JFK_TWR", Name: "Kennedy Tower", CID: 123456},
+				},
+			},
+		},
+		FetchDuration: 250 * time.Millisecond,
+		FetchedAt:     time.Now(),
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeMetrics(w, testSnapshot())
+	body := w.Body.String()
+
+	for _, want := range []string{
+		`metroline_departures{airport="KJFK"} 3`,
+		`metroline_arrivals{airport="KJFK"} 2`,
+		`metroline_controllers_online{facility="N90",position="JFK_TWR"} 1`,
+		`metroline_recently_departed{facility="N90"} 1`,
+		`metroline_go_arounds{facility="N90"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q; got:\n%s", want, body)
+		}
+	}
+}
+
+func TestWriteTrafficJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeTrafficJSON(w, testSnapshot())
+
+	var entries []trafficEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+	got := entries[0]
+	want := trafficEntry{Facility: "N90", Airport: "KJFK", Departures: 3, Arrivals: 2}
+	if got != want {
+		t.Errorf("entries[0] = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteControllersJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeControllersJSON(w, testSnapshot())
+
+	var entries []controllerEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+	got := entries[0]
+	want := controllerEntry{Facility: "N90", Position: "JFK_TWR", Name: "Kennedy Tower", CID: 123456}
+	if got != want {
+		t.Errorf("entries[0] = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+// Package track maintains short position histories for aircraft seen in
+// the VATSIM datafeed, CID-keyed in the same way Stratux keys its
+// traffic map by ICAO address. Since metroline runs on a snapshot every
+// invocation rather than as a long-lived process, the store is persisted
+// to the on-disk cache so history survives between runs.
+package track
+
+import (
+	"time"
+
+	"github.com/mmp/metroline/cache"
+)
+
+// MaxPositions caps how many recent position reports are kept per
+// aircraft.
+const MaxPositions = 8
+
+// StaleAfter is how long an aircraft can go without an update before
+// Prune drops it, matching the cleanup idiom Stratux uses for its
+// traffic map.
+const StaleAfter = 60 * time.Second
+
+// Position is a single position report.
+type Position struct {
+	Time          time.Time
+	Lat, Lon      float32
+	AltitudeFt    int
+	GroundspeedKt int
+}
+
+// Aircraft is the position history and derived state for one CID.
+type Aircraft struct {
+	CID             int
+	Callsign        string
+	LastSeen        time.Time
+	Positions       []Position // oldest first, capped at MaxPositions
+	VerticalRateFPM float64
+	OnGround        bool
+}
+
+// WasOnGroundWithin reports whether the aircraft's groundspeed indicated
+// it was on the ground at any point within d of now. It approximates
+// historical ground state from groundspeed alone, since OnGround itself
+// is only recorded for the most recent report.
+func (ac *Aircraft) WasOnGroundWithin(now time.Time, d time.Duration) bool {
+	for i := len(ac.Positions) - 1; i >= 0; i-- {
+		p := ac.Positions[i]
+		if now.Sub(p.Time) > d {
+			break
+		}
+		if p.GroundspeedKt < 40 {
+			return true
+		}
+	}
+	return false
+}
+
+// WentAround reports whether the aircraft's recent track shows a
+// descent followed by a climb of at least 300ft — the signature of a
+// go-around. Only the most recent report's OnGround is consulted;
+// earlier reports are not checked for ground contact.
+func (ac *Aircraft) WentAround() bool {
+	if ac.OnGround || len(ac.Positions) < 2 {
+		return false
+	}
+
+	sawDescent := false
+	minAlt := ac.Positions[0].AltitudeFt
+	for i := 1; i < len(ac.Positions); i++ {
+		// A new low below the first report can only be reached by
+		// descending, so the climb is measured from after a descent.
+		if ac.Positions[i].AltitudeFt < minAlt {
+			minAlt = ac.Positions[i].AltitudeFt
+			sawDescent = true
+		}
+	}
+
+	last := ac.Positions[len(ac.Positions)-1]
+	return sawDescent && last.AltitudeFt >= minAlt+300
+}
+
+// Store is a CID-keyed set of aircraft tracks.
+type Store struct {
+	Aircraft map[int]*Aircraft
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{Aircraft: make(map[int]*Aircraft)}
+}
+
+const cacheKey = "track-store"
+
+// Load restores the Store persisted by the previous invocation, or
+// returns an empty one if there isn't a cached store yet.
+func Load() *Store {
+	s := NewStore()
+	if cache.LoadValue(cacheKey, s) && s.Aircraft == nil {
+		s.Aircraft = make(map[int]*Aircraft)
+	}
+	return s
+}
+
+// Save persists the Store for the next invocation to pick up with Load.
+func (s *Store) Save() error {
+	return cache.SaveValue(cacheKey, s)
+}
+
+// Update records a new position report for cid, appending to its
+// history, computing vertical rate from the prior report, and deriving
+// OnGround from groundspeed and altitude above the nearest field
+// elevation (fieldElevationFt), in the style of Stratux's ground
+// heuristic.
+func (s *Store) Update(cid int, callsign string, now time.Time, lat, lon float32, altitudeFt, groundspeedKt, fieldElevationFt int) *Aircraft {
+	ac, ok := s.Aircraft[cid]
+	if !ok {
+		ac = &Aircraft{CID: cid}
+		s.Aircraft[cid] = ac
+	}
+	ac.Callsign = callsign
+	ac.LastSeen = now
+
+	if n := len(ac.Positions); n > 0 {
+		prev := ac.Positions[n-1]
+		if dt := now.Sub(prev.Time).Minutes(); dt > 0 {
+			ac.VerticalRateFPM = float64(altitudeFt-prev.AltitudeFt) / dt
+		}
+	}
+
+	ac.Positions = append(ac.Positions, Position{
+		Time: now, Lat: lat, Lon: lon, AltitudeFt: altitudeFt, GroundspeedKt: groundspeedKt,
+	})
+	if len(ac.Positions) > MaxPositions {
+		ac.Positions = ac.Positions[len(ac.Positions)-MaxPositions:]
+	}
+
+	ac.OnGround = groundspeedKt < 40 && altitudeFt-fieldElevationFt < 500
+
+	return ac
+}
+
+// Prune removes aircraft that haven't been updated within maxAge of now.
+func (s *Store) Prune(now time.Time, maxAge time.Duration) {
+	for cid, ac := range s.Aircraft {
+		if now.Sub(ac.LastSeen) > maxAge {
+			delete(s.Aircraft, cid)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package track
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateDerivesOnGround(t *testing.T) {
+	s := NewStore()
+	now := time.Now()
+
+	ac := s.Update(100, "DAL123", now, 40.64, -73.78, 20, 5, 13) // taxiing at JFK (elev 13ft)
+	if !ac.OnGround {
+		t.Error("expected OnGround for slow aircraft near field elevation")
+	}
+
+	ac = s.Update(100, "DAL123", now.Add(30*time.Second), 40.65, -73.79, 2000, 180, 13)
+	if ac.OnGround {
+		t.Error("expected airborne after climbing away from the field")
+	}
+	if ac.VerticalRateFPM <= 0 {
+		t.Errorf("VerticalRateFPM = %v, want positive after a climb", ac.VerticalRateFPM)
+	}
+}
+
+func TestWasOnGroundWithin(t *testing.T) {
+	s := NewStore()
+	now := time.Now()
+
+	s.Update(200, "N123AB", now, 40.64, -73.78, 20, 5, 13)
+	s.Update(200, "N123AB", now.Add(2*time.Minute), 40.70, -73.70, 1500, 120, 13)
+
+	ac := s.Aircraft[200]
+	if !ac.WasOnGroundWithin(now.Add(2*time.Minute), 10*time.Minute) {
+		t.Error("expected recent ground position to be found within 10 minutes")
+	}
+	if ac.WasOnGroundWithin(now.Add(20*time.Minute), 10*time.Minute) {
+		t.Error("ground position is now outside the 10 minute window")
+	}
+}
+
+func TestWentAround(t *testing.T) {
+	s := NewStore()
+	now := time.Now()
+
+	// Descend toward the field, then climb away without ever landing.
+	alts := []int{3000, 1500, 600, 1200, 2500}
+	var ac *Aircraft
+	for i, alt := range alts {
+		ac = s.Update(300, "JBU45", now.Add(time.Duration(i)*20*time.Second), 40.64, -73.78, alt, 140, 13)
+	}
+	if !ac.WentAround() {
+		t.Error("expected a go-around to be detected")
+	}
+}
+
+func TestPruneDropsStaleAircraft(t *testing.T) {
+	s := NewStore()
+	now := time.Now()
+
+	s.Update(400, "AAL1", now, 40.64, -73.78, 2000, 200, 13)
+	s.Prune(now.Add(2*time.Minute), StaleAfter)
+
+	if _, ok := s.Aircraft[400]; ok {
+		t.Error("expected stale aircraft to be pruned")
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	s := NewStore()
+	now := time.Now()
+	s.Update(500, "SWA1", now, 40.64, -73.78, 2000, 200, 13)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := Load()
+	ac, ok := loaded.Aircraft[500]
+	if !ok {
+		t.Fatal("expected aircraft 500 to survive a save/load round trip")
+	}
+	if ac.Callsign != "SWA1" {
+		t.Errorf("Callsign = %q, want SWA1", ac.Callsign)
+	}
+}